@@ -17,12 +17,14 @@ package cluster
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"errors"
 	"fmt"
 	weakrand "math/rand"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/crunchydata/postgres-operator/internal/config"
@@ -37,9 +39,16 @@ import (
 	log "github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
 )
 
 const (
@@ -58,6 +67,221 @@ type pgAdminTemplateFields struct {
 	InitUser       string
 	InitPass       string
 	PVCName        string
+	Replicas       int32
+
+	// UsesSharedBackend and the ConfigDB* fields below are only populated
+	// when pgAdmin is deployed against a shared, external configuration
+	// database instead of the default per-pod SQLite file, which is what
+	// allows Replicas to be greater than 1
+	UsesSharedBackend      bool
+	ConfigDBHost           string
+	ConfigDBPort           string
+	ConfigDBUser           string
+	ConfigDBPasswordSecret string
+
+	// AuthSources lists the pgAdmin authentication sources to enable, e.g.
+	// "internal" plus any of "google", "github", "azure" or "oidc"
+	AuthSources []string
+}
+
+// pgAdminExposeType represents the means by which the pgAdmin Service is
+// made reachable from outside of the Kubernetes cluster
+type pgAdminExposeType string
+
+const (
+	pgAdminExposeClusterIP    pgAdminExposeType = "ClusterIP"
+	pgAdminExposeNodePort     pgAdminExposeType = "NodePort"
+	pgAdminExposeLoadBalancer pgAdminExposeType = "LoadBalancer"
+	pgAdminExposeIngress      pgAdminExposeType = "Ingress"
+)
+
+// parameter keys read from a pgtask's Spec.Parameters map when pgAdmin is
+// added via AddPgAdminFromPgTask, controlling how the pgAdmin Service is
+// exposed outside of the cluster
+const (
+	paramPgAdminExposeType   = "pgadmin-expose-type"
+	paramPgAdminIngressHost  = "pgadmin-ingress-host"
+	paramPgAdminIngressPath  = "pgadmin-ingress-path"
+	paramPgAdminIngressClass = "pgadmin-ingress-class"
+	paramPgAdminIssuerName   = "pgadmin-issuer-name"
+	paramPgAdminIssuerKind   = "pgadmin-issuer-kind" // "Issuer" or "ClusterIssuer"
+	// paramPgAdminHTTPAdminSecret names a Secret holding the "username" and
+	// "password" of a persistent pgAdmin admin account. When set alongside
+	// Ingress exposure, GetPgAdminQueryRunner drives pgAdmin through its own
+	// REST API as that account instead of exec-ing into the pod, since an
+	// Ingress-only pgAdmin may not be reachable any other way
+	paramPgAdminHTTPAdminSecret = "pgadmin-http-admin-secret"
+)
+
+// pgAdminIngressFormat is the name of the Kubernetes Ingress that fronts
+// pgAdmin, and follows the format "<clusterName>-pgadmin"
+const pgAdminIngressFormat = "%s-pgadmin"
+
+// certManagerIssuerAnnotation and certManagerClusterIssuerAnnotation are the
+// cert-manager annotations used to request a TLS certificate for the
+// pgAdmin Ingress, depending on whether the referenced issuer is namespaced
+// or cluster-scoped
+const (
+	certManagerIssuerAnnotation        = "cert-manager.io/issuer"
+	certManagerClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// pgAdminExposeSpec describes how the pgAdmin Service should be made
+// reachable from outside of the Kubernetes cluster. It is derived from the
+// parameters passed in on the pgtask that triggers AddPgAdmin
+type pgAdminExposeSpec struct {
+	Type pgAdminExposeType
+
+	// IngressHost, IngressPath and IngressClass are only consulted when
+	// Type is pgAdminExposeIngress
+	IngressHost  string
+	IngressPath  string
+	IngressClass string
+
+	// IssuerName and IssuerKind, when set, cause the generated Ingress to be
+	// annotated for cert-manager so that it provisions a TLS certificate.
+	// IssuerKind is either "Issuer" or "ClusterIssuer"
+	IssuerName string
+	IssuerKind string
+
+	// HTTPAdminSecret, when set alongside Type == pgAdminExposeIngress,
+	// selects the HTTP QueryRunner: GetPgAdminQueryRunner logs into pgAdmin's
+	// REST API as this Secret's account instead of exec-ing into the pod
+	HTTPAdminSecret string
+}
+
+// pgAdminExposeSpecFromParameters builds a pgAdminExposeSpec from a pgtask's
+// Spec.Parameters map, defaulting to a plain in-cluster ClusterIP Service
+// when no expose type is specified
+func pgAdminExposeSpecFromParameters(parameters map[string]string) pgAdminExposeSpec {
+	spec := pgAdminExposeSpec{
+		Type:            pgAdminExposeType(parameters[paramPgAdminExposeType]),
+		IngressHost:     parameters[paramPgAdminIngressHost],
+		IngressPath:     parameters[paramPgAdminIngressPath],
+		IngressClass:    parameters[paramPgAdminIngressClass],
+		IssuerName:      parameters[paramPgAdminIssuerName],
+		IssuerKind:      parameters[paramPgAdminIssuerKind],
+		HTTPAdminSecret: parameters[paramPgAdminHTTPAdminSecret],
+	}
+
+	if spec.Type == "" {
+		spec.Type = pgAdminExposeClusterIP
+	}
+
+	if spec.IngressPath == "" {
+		spec.IngressPath = "/"
+	}
+
+	if spec.IssuerKind == "" {
+		spec.IssuerKind = "ClusterIssuer"
+	}
+
+	return spec
+}
+
+// parameter keys read from a pgtask's Spec.Parameters map that control
+// whether pgAdmin is deployed against a shared, external configuration
+// database rather than the default per-pod SQLite file
+const (
+	paramPgAdminReplicas               = "pgadmin-replicas"
+	paramPgAdminConfigDBHost           = "pgadmin-configdb-host"
+	paramPgAdminConfigDBPort           = "pgadmin-configdb-port"
+	paramPgAdminConfigDBUser           = "pgadmin-configdb-user"
+	paramPgAdminConfigDBPasswordSecret = "pgadmin-configdb-password-secret"
+)
+
+// defConfigDBPort is used when a shared configuration database is selected
+// but no port is specified
+const defConfigDBPort = "5432"
+
+// pgAdminBackendSpec describes the configuration database backend that
+// pgAdmin should use to store its own settings and user accounts. When
+// ConfigDBHost is unset, pgAdmin falls back to the default single-replica
+// SQLite file stored on a PVC
+type pgAdminBackendSpec struct {
+	Replicas               int32
+	ConfigDBHost           string
+	ConfigDBPort           string
+	ConfigDBUser           string
+	ConfigDBPasswordSecret string
+}
+
+// Shared returns true when pgAdmin should be configured to use a shared,
+// external configuration database instead of the per-pod SQLite file
+func (s pgAdminBackendSpec) Shared() bool {
+	return s.ConfigDBHost != ""
+}
+
+// pgAdminBackendSpecFromParameters builds a pgAdminBackendSpec from a
+// pgtask's Spec.Parameters map, defaulting to a single replica backed by
+// the per-pod SQLite file when no configuration database is specified
+func pgAdminBackendSpecFromParameters(parameters map[string]string) pgAdminBackendSpec {
+	spec := pgAdminBackendSpec{
+		Replicas:               1,
+		ConfigDBHost:           parameters[paramPgAdminConfigDBHost],
+		ConfigDBPort:           parameters[paramPgAdminConfigDBPort],
+		ConfigDBUser:           parameters[paramPgAdminConfigDBUser],
+		ConfigDBPasswordSecret: parameters[paramPgAdminConfigDBPasswordSecret],
+	}
+
+	if replicas, err := strconv.Atoi(parameters[paramPgAdminReplicas]); err == nil && replicas > 0 {
+		spec.Replicas = int32(replicas)
+	}
+
+	if spec.ConfigDBPort == "" {
+		spec.ConfigDBPort = defConfigDBPort
+	}
+
+	return spec
+}
+
+// parameter keys read from a pgtask's Spec.Parameters map that configure
+// OAuth2/OIDC single sign-on for pgAdmin
+const (
+	// paramPgAdminOAuthProviders is a comma-separated list drawn from
+	// "google", "github", "azure" and "oidc"
+	paramPgAdminOAuthProviders = "pgadmin-oauth-providers"
+	// paramPgAdminOAuthSecret names the Secret holding the client_id,
+	// client_secret and (for azure/oidc) issuer_url for each enabled provider
+	paramPgAdminOAuthSecret = "pgadmin-oauth-secret"
+)
+
+// oauthProviders is the set of OAuth2/OIDC providers pgAdmin can be
+// configured against
+var oauthProviders = map[string]bool{
+	"google": true,
+	"github": true,
+	"azure":  true,
+	"oidc":   true,
+}
+
+// pgAdminOAuthSpec describes the OAuth2/OIDC providers pgAdmin should trust
+// for single sign-on, and the Secret holding their client credentials
+type pgAdminOAuthSpec struct {
+	Providers  []string
+	SecretName string
+}
+
+// Enabled returns true when at least one OAuth2/OIDC provider is configured
+func (s pgAdminOAuthSpec) Enabled() bool {
+	return len(s.Providers) > 0
+}
+
+// pgAdminOAuthSpecFromParameters builds a pgAdminOAuthSpec from a pgtask's
+// Spec.Parameters map, ignoring any provider name it doesn't recognize
+func pgAdminOAuthSpecFromParameters(parameters map[string]string) pgAdminOAuthSpec {
+	spec := pgAdminOAuthSpec{
+		SecretName: parameters[paramPgAdminOAuthSecret],
+	}
+
+	for _, name := range strings.Split(parameters[paramPgAdminOAuthProviders], ",") {
+		name = strings.TrimSpace(name)
+		if oauthProviders[name] {
+			spec.Providers = append(spec.Providers, name)
+		}
+	}
+
+	return spec
 }
 
 // pgAdminDeploymentFormat is the name of the Kubernetes Deployment that
@@ -67,10 +291,44 @@ const pgAdminDeploymentFormat = "%s-pgadmin"
 // initPassLen is the length of the one-time setup password for pgadmin
 const initPassLen = 20
 
-const (
-	deployTimeout = 60
-	pollInterval  = 3
-)
+const deployTimeout = 60
+
+// pgAdminParamsAnnotation, set on the Pgcluster CR by AddPgAdmin, holds the
+// JSON-encoded pgAdminCreationParams used to create this cluster's pgAdmin.
+// The informer-driven reconciler reads it back via
+// pgAdminCreationParamsFromCluster so it can safely recreate pgAdmin in its
+// original mode if the Deployment ever goes missing outside of a
+// user-requested delete, instead of guessing
+const pgAdminParamsAnnotation = "pgo-pgadmin-params"
+
+// pgAdminCreationParams bundles every parameter AddPgAdmin needs to
+// (re)create a pgAdmin Deployment in its original mode
+type pgAdminCreationParams struct {
+	Storage crv1.PgStorageSpec
+	Expose  pgAdminExposeSpec
+	Backend pgAdminBackendSpec
+	OAuth   pgAdminOAuthSpec
+}
+
+// pgAdminCreationParamsFromCluster reads back the pgAdminCreationParams
+// persisted on cluster by AddPgAdmin. ok is false when the cluster predates
+// this annotation or the annotation can't be parsed, in which case only the
+// idempotent bootstrap and delete paths are safe - recreating with
+// defaulted parameters would silently discard the cluster's original
+// configuration
+func pgAdminCreationParamsFromCluster(cluster *crv1.Pgcluster) (params pgAdminCreationParams, ok bool) {
+	raw, exists := cluster.Annotations[pgAdminParamsAnnotation]
+	if !exists {
+		return pgAdminCreationParams{}, false
+	}
+
+	if err := json.Unmarshal([]byte(raw), &params); err != nil {
+		log.Errorf("could not parse %s annotation on cluster [%s]: %v", pgAdminParamsAnnotation, cluster.Name, err)
+		return pgAdminCreationParams{}, false
+	}
+
+	return params, true
+}
 
 // AddPgAdmin contains the various functions that are used to add a pgAdmin
 // Deployment to a PostgreSQL cluster
@@ -80,7 +338,10 @@ func AddPgAdmin(
 	clientset kubeapi.Interface,
 	restconfig *rest.Config,
 	cluster *crv1.Pgcluster,
-	storageClass *crv1.PgStorageSpec) error {
+	storageClass *crv1.PgStorageSpec,
+	exposeSpec pgAdminExposeSpec,
+	backendSpec pgAdminBackendSpec,
+	oauthSpec pgAdminOAuthSpec) error {
 	log.Debugf("adding pgAdmin")
 
 	// first, ensure that the Cluster CR is updated to know that there is now
@@ -88,9 +349,27 @@ func AddPgAdmin(
 	// such as if the pgAdmin is being added via a pgtask, and as such the
 	// values for memory/CPU may be set as well.
 	//
+	// Its creation parameters are persisted here too, so the informer-driven
+	// reconciler can recreate pgAdmin in the same mode if its Deployment
+	// ever goes missing unexpectedly
+	//
 	// if we cannot update this we abort
 	cluster.Labels[config.LABEL_PGADMIN] = "true"
 
+	params, err := json.Marshal(pgAdminCreationParams{
+		Storage: *storageClass,
+		Expose:  exposeSpec,
+		Backend: backendSpec,
+		OAuth:   oauthSpec,
+	})
+	if err != nil {
+		return err
+	}
+	if cluster.Annotations == nil {
+		cluster.Annotations = map[string]string{}
+	}
+	cluster.Annotations[pgAdminParamsAnnotation] = string(params)
+
 	ns := cluster.Namespace
 
 	if _, err := clientset.CrunchydataV1().Pgclusters(ns).Update(cluster); err != nil {
@@ -100,24 +379,37 @@ func AddPgAdmin(
 	// Using deployment/service name for PVC also
 	pvcName := fmt.Sprintf(pgAdminDeploymentFormat, cluster.Name)
 
-	// create the pgAdmin storage volume
-	if _, err := pvc.CreateIfNotExists(clientset, *storageClass, pvcName, cluster.Name, ns); err != nil {
-		log.Errorf("Error creating PVC: %s", err.Error())
-		return err
-	} else {
-		log.Info("created pgadmin PVC =" + pvcName + " in namespace " + ns)
+	// the per-pod SQLite PVC is only needed when pgAdmin isn't configured
+	// against a shared, external configuration database
+	if !backendSpec.Shared() {
+		// create the pgAdmin storage volume
+		if _, err := pvc.CreateIfNotExists(clientset, *storageClass, pvcName, cluster.Name, ns); err != nil {
+			log.Errorf("Error creating PVC: %s", err.Error())
+			return err
+		} else {
+			log.Info("created pgadmin PVC =" + pvcName + " in namespace " + ns)
+		}
 	}
 
 	// create the pgAdmin deployment
-	if err := createPgAdminDeployment(clientset, cluster, pvcName); err != nil {
+	if err := createPgAdminDeployment(clientset, cluster, pvcName, exposeSpec, backendSpec, oauthSpec); err != nil {
 		return err
 	}
 
 	// create the pgAdmin service
-	if err := createPgAdminService(clientset, cluster); err != nil {
+	if err := createPgAdminService(clientset, cluster, exposeSpec); err != nil {
 		return err
 	}
 
+	// if the pgAdmin is being exposed via an Ingress, create it now that the
+	// backing Service exists
+	if exposeSpec.Type == pgAdminExposeIngress {
+		if err := createPgAdminIngress(clientset, cluster, exposeSpec); err != nil {
+			log.Errorf("Error creating Ingress: %s", err.Error())
+			return err
+		}
+	}
+
 	log.Debugf("added pgAdmin to cluster [%s]", cluster.Name)
 
 	return nil
@@ -141,7 +433,10 @@ func AddPgAdminFromPgTask(clientset kubeapi.Interface, restconfig *rest.Config,
 	}
 
 	// bring up the pgAdmin deployment
-	if err := AddPgAdmin(clientset, restconfig, cluster, &storage); err != nil {
+	exposeSpec := pgAdminExposeSpecFromParameters(task.Spec.Parameters)
+	backendSpec := pgAdminBackendSpecFromParameters(task.Spec.Parameters)
+	oauthSpec := pgAdminOAuthSpecFromParameters(task.Spec.Parameters)
+	if err := AddPgAdmin(clientset, restconfig, cluster, &storage, exposeSpec, backendSpec, oauthSpec); err != nil {
 		log.Error(err)
 		return
 	}
@@ -156,16 +451,85 @@ func AddPgAdminFromPgTask(clientset kubeapi.Interface, restconfig *rest.Config,
 	}
 
 	deployName := fmt.Sprintf(pgAdminDeploymentFormat, clusterName)
-	if err := waitForDeploymentReady(clientset, namespace, deployName, deployTimeout, pollInterval); err != nil {
+	if err := waitForDeploymentReady(clientset, namespace, deployName, deployTimeout); err != nil {
 		log.Error(err)
 	}
 
-	// Lock down setup user and prepopulate connections for managed users
-	if err := BootstrapPgAdminUsers(clientset, restconfig, cluster); err != nil {
+	// Lock down setup user and prepopulate connections for managed users.
+	// This goes through the same retrying bootstrap path that the reconciler
+	// uses in pgAdminController, so a pod that isn't quite ready yet
+	// doesn't leave the cluster without a bootstrapped pgAdmin
+	if err := newPgAdminController(clientset, restconfig).bootstrapWithBackoff(cluster, oauthSpec); err != nil {
 		log.Error(err)
 	}
 }
 
+// BootstrapPgAdminOAuthUsers registers pgAdmin users that authenticate via
+// the OAuth2/OIDC providers configured in createPgAdminDeployment, and
+// pre-populates their server connections the same way BootstrapPgAdminUsers
+// does for internal users. It runs alongside BootstrapPgAdminUsers rather
+// than replacing it, since a cluster can allow both internal and SSO logins
+// at once
+func BootstrapPgAdminOAuthUsers(
+	clientset kubernetes.Interface,
+	restconfig *rest.Config,
+	cluster *crv1.Pgcluster) error {
+
+	qr, err := pgadmin.GetPgAdminQueryRunner(clientset, restconfig, cluster)
+	if err != nil {
+		return err
+	} else if qr == nil {
+		return nil
+	}
+	defer qr.Close()
+
+	service, err := clientset.CoreV1().Services(cluster.Namespace).Get(cluster.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	dbService := pgadmin.ServerEntryFromPgService(service, cluster.Name)
+
+	sel := fmt.Sprintf("%s=%s", config.LABEL_PG_CLUSTER, cluster.Name)
+	secretList, err := clientset.
+		CoreV1().Secrets(cluster.Namespace).
+		List(metav1.ListOptions{LabelSelector: sel})
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secretList.Items {
+		uname, ok := secret.Data["username"]
+		if !ok {
+			continue
+		}
+		user := string(uname[:])
+		if secret.Name != fmt.Sprintf("%s-%s-secret", cluster.Name, user) {
+			continue
+		}
+		if util.IsPostgreSQLUserSystemAccount(user) {
+			continue
+		}
+
+		if err := pgadmin.SetOAuthUser(qr, user); err != nil {
+			return err
+		}
+
+		if dbService.Name != "" {
+			if err := pgadmin.SetClusterConnection(qr, user, dbService); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// BootstrapPgAdminUsers talks to pgAdmin exclusively through the
+// pgadmin.QueryRunner returned by GetPgAdminQueryRunner, so it works the
+// same whether pgAdmin is backed by the default per-pod SQLite file or a
+// shared configuration database - GetPgAdminQueryRunner is what decides
+// which one pod 0 is exec'd into versus a direct connection
 func BootstrapPgAdminUsers(
 	clientset kubernetes.Interface,
 	restconfig *rest.Config,
@@ -178,10 +542,10 @@ func BootstrapPgAdminUsers(
 		// Cluster doesn't claim to have pgAdmin setup, we're done here
 		return nil
 	}
+	defer qr.Close()
 
 	// Disables setup user and breaks the password hash value
-	err = qr.Exec("UPDATE user SET active = 0, password = substr(password,1,50) WHERE id=1;")
-	if err != nil {
+	if err := qr.LockSetupUser(); err != nil {
 		log.Errorf("failed to lock down pgadmin db [%v], deleting instance", err)
 		return err
 	}
@@ -291,6 +655,22 @@ func DeletePgAdmin(clientset kubeapi.Interface, restconfig *rest.Config, cluster
 		log.Warn(err)
 	}
 
+	// Delete the config_local.py ConfigMap, if one was rendered for this
+	// pgAdmin. Not every pgAdmin has one, so a NotFound error here is
+	// expected and ignorable. Without this, a delete followed by a recreate
+	// would find the ConfigMap still around and fail with AlreadyExists
+	pgAdminConfigMapName := fmt.Sprintf(pgAdminConfigLocalConfigMapFormat, clusterName)
+	if err := clientset.CoreV1().ConfigMaps(namespace).Delete(pgAdminConfigMapName, &metav1.DeleteOptions{}); err != nil {
+		log.Debug(err)
+	}
+
+	// Delete the Ingress, if one was created for this pgAdmin. Not every
+	// pgAdmin has one, so a NotFound error here is expected and ignorable
+	pgAdminIngressName := fmt.Sprintf(pgAdminIngressFormat, clusterName)
+	if err := clientset.NetworkingV1().Ingresses(namespace).Delete(pgAdminIngressName, &metav1.DeleteOptions{}); err != nil {
+		log.Debug(err)
+	}
+
 	return nil
 }
 
@@ -325,8 +705,11 @@ func DeletePgAdminFromPgTask(clientset kubeapi.Interface, restconfig *rest.Confi
 	}
 }
 
-// createPgAdminDeployment creates the Kubernetes Deployment for pgAdmin
-func createPgAdminDeployment(clientset kubernetes.Interface, cluster *crv1.Pgcluster, pvcName string) error {
+// createPgAdminDeployment creates the Kubernetes Deployment for pgAdmin. When
+// backendSpec selects a shared configuration database, a config_local.py is
+// rendered into a ConfigMap and mounted into the pgAdmin container so that
+// every replica points at the same backend instead of its own SQLite file
+func createPgAdminDeployment(clientset kubernetes.Interface, cluster *crv1.Pgcluster, pvcName string, exposeSpec pgAdminExposeSpec, backendSpec pgAdminBackendSpec, oauthSpec pgAdminOAuthSpec) error {
 	log.Debugf("creating pgAdmin deployment: %s", cluster.Name)
 
 	// derive the name of the Deployment...which is also used as the name of the
@@ -344,15 +727,22 @@ func createPgAdminDeployment(clientset kubernetes.Interface, cluster *crv1.Pgclu
 
 	// get the fields that will be substituted in the pgAdmin template
 	fields := pgAdminTemplateFields{
-		Name:           pgAdminDeploymentName,
-		ClusterName:    cluster.Name,
-		CCPImagePrefix: operator.Pgo.Cluster.CCPImagePrefix,
-		CCPImageTag:    cluster.Spec.CCPImageTag,
-		DisableFSGroup: operator.Pgo.Cluster.DisableFSGroup,
-		Port:           defPgAdminPort,
-		InitUser:       defSetupUsername,
-		InitPass:       throwawayPass,
-		PVCName:        pvcName,
+		Name:                   pgAdminDeploymentName,
+		ClusterName:            cluster.Name,
+		CCPImagePrefix:         operator.Pgo.Cluster.CCPImagePrefix,
+		CCPImageTag:            cluster.Spec.CCPImageTag,
+		DisableFSGroup:         operator.Pgo.Cluster.DisableFSGroup,
+		Port:                   defPgAdminPort,
+		InitUser:               defSetupUsername,
+		InitPass:               throwawayPass,
+		PVCName:                pvcName,
+		Replicas:               backendSpec.Replicas,
+		UsesSharedBackend:      backendSpec.Shared(),
+		ConfigDBHost:           backendSpec.ConfigDBHost,
+		ConfigDBPort:           backendSpec.ConfigDBPort,
+		ConfigDBUser:           backendSpec.ConfigDBUser,
+		ConfigDBPasswordSecret: backendSpec.ConfigDBPasswordSecret,
+		AuthSources:            append([]string{"internal"}, oauthSpec.Providers...),
 	}
 
 	// For debugging purposes, put the template substitution in stdout
@@ -378,6 +768,30 @@ func createPgAdminDeployment(clientset kubernetes.Interface, cluster *crv1.Pgclu
 	operator.SetContainerImageOverride(config.CONTAINER_IMAGE_CRUNCHY_PGADMIN,
 		&deployment.Spec.Template.Spec.Containers[0])
 
+	// render and mount a config_local.py whenever there's something to put in
+	// it: a shared configuration database to point SQLAlchemy at, OAuth2/OIDC
+	// providers to wire up, or both
+	if backendSpec.Shared() || oauthSpec.Enabled() {
+		if err := createPgAdminConfigLocalConfigMap(clientset, cluster, backendSpec, oauthSpec); err != nil {
+			return err
+		}
+
+		mountPgAdminConfigLocal(&deployment, cluster.Name)
+
+		if backendSpec.Shared() {
+			mountPgAdminConfigDBPassword(&deployment, backendSpec)
+		}
+
+		if oauthSpec.Enabled() {
+			mountPgAdminOAuthSecret(&deployment, oauthSpec)
+		}
+	}
+
+	// record which pgadmin.QueryRunner implementation manages this pgAdmin,
+	// so GetPgAdminQueryRunner doesn't have to re-derive it from the rest of
+	// the Deployment's shape
+	annotatePgAdminBackendMode(&deployment, exposeSpec, backendSpec)
+
 	if _, err := clientset.AppsV1().Deployments(cluster.Namespace).Create(&deployment); err != nil {
 		return err
 	}
@@ -385,8 +799,229 @@ func createPgAdminDeployment(clientset kubernetes.Interface, cluster *crv1.Pgclu
 	return nil
 }
 
-// createPgAdminService creates the Kubernetes Service for pgAdmin
-func createPgAdminService(clientset kubernetes.Interface, cluster *crv1.Pgcluster) error {
+// pgAdminConfigLocalConfigMapFormat is the name of the ConfigMap holding the
+// rendered config_local.py, and follows the format "<clusterName>-pgadmin-config"
+const pgAdminConfigLocalConfigMapFormat = "%s-pgadmin-config"
+
+// configLocalDBFormat renders the portion of config_local.py that points
+// pgAdmin's SQLAlchemy URI at the shared configuration database instead of
+// the default per-pod SQLite file. The password is intentionally left out
+// of the ConfigMap and substituted at container start from the referenced
+// Secret via the PGADMIN_CONFIG_DB_PASSWORD environment variable
+const configLocalDBFormat = `
+SQLALCHEMY_DATABASE_URI = (
+    "postgresql://%s:" + os.environ["PGADMIN_CONFIG_DB_PASSWORD"] +
+    "@%s:%s/pgadmin"
+)
+`
+
+// configLocalOAuthFormat renders the portion of config_local.py that
+// enables pgAdmin's OAuth2/OIDC authentication sources. AUTHENTICATION_SOURCES
+// takes pgAdmin's own source identifiers, not provider names - "internal" is
+// always kept alongside "oauth2" so password login still works for users who
+// aren't using SSO. client_id and client_secret for each provider are read
+// at container start from the Secret mounted by mountPgAdminOAuthSecret,
+// rather than templated in here
+const configLocalOAuthFormat = `
+AUTHENTICATION_SOURCES = ["internal", "oauth2"]
+OAUTH2_AUTO_CREATE_USER = True
+OAUTH2_CONFIG = _oauth2_config_from_env(%s)
+`
+
+// oauthConfigHelperPy is a small helper, shared by every provider, that
+// assembles pgAdmin's OAUTH2_CONFIG list from the client credentials and
+// issuer URLs mounted into the pod from the user-referenced Secret
+const oauthConfigHelperPy = `
+import json
+import os
+
+def _oauth2_config_from_env(providers):
+    configs = []
+    for provider in providers:
+        prefix = provider.upper()
+        config = {
+            "OAUTH2_NAME": provider,
+            "OAUTH2_DISPLAY_NAME": provider.capitalize(),
+            "OAUTH2_CLIENT_ID": os.environ.get(prefix + "_CLIENT_ID", ""),
+            "OAUTH2_CLIENT_SECRET": os.environ.get(prefix + "_CLIENT_SECRET", ""),
+        }
+        issuer = os.environ.get(prefix + "_ISSUER_URL", "")
+        if issuer:
+            config["OAUTH2_SERVER_METADATA_URL"] = issuer.rstrip("/") + "/.well-known/openid-configuration"
+        configs.append(config)
+    return configs
+`
+
+// renderConfigLocalPy builds the contents of config_local.py for the shared
+// configuration database and/or OAuth2/OIDC SSO modes, or just the "import
+// os" stub when neither is enabled
+func renderConfigLocalPy(backendSpec pgAdminBackendSpec, oauthSpec pgAdminOAuthSpec) string {
+	configLocalPy := "import os\n"
+
+	if oauthSpec.Enabled() {
+		configLocalPy += oauthConfigHelperPy
+	}
+
+	if backendSpec.Shared() {
+		configLocalPy += fmt.Sprintf(configLocalDBFormat,
+			backendSpec.ConfigDBUser, backendSpec.ConfigDBHost, backendSpec.ConfigDBPort)
+	}
+
+	if oauthSpec.Enabled() {
+		providers, _ := json.Marshal(oauthSpec.Providers)
+		configLocalPy += fmt.Sprintf(configLocalOAuthFormat, providers)
+	}
+
+	return configLocalPy
+}
+
+// createPgAdminConfigLocalConfigMap creates (or updates) the ConfigMap that
+// holds pgAdmin's config_local.py for the shared configuration database
+// and/or OAuth2/OIDC SSO modes
+func createPgAdminConfigLocalConfigMap(clientset kubernetes.Interface, cluster *crv1.Pgcluster, backendSpec pgAdminBackendSpec, oauthSpec pgAdminOAuthSpec) error {
+	configMapName := fmt.Sprintf(pgAdminConfigLocalConfigMapFormat, cluster.Name)
+
+	configLocalPy := renderConfigLocalPy(backendSpec, oauthSpec)
+
+	configMap := v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      configMapName,
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				config.LABEL_PG_CLUSTER: cluster.Name,
+				config.LABEL_PGADMIN:    "true",
+			},
+		},
+		Data: map[string]string{
+			"config_local.py": configLocalPy,
+		},
+	}
+
+	if _, err := clientset.CoreV1().ConfigMaps(cluster.Namespace).Create(&configMap); err != nil {
+		if !kerrors.IsAlreadyExists(err) {
+			return err
+		}
+
+		existing, err := clientset.CoreV1().ConfigMaps(cluster.Namespace).Get(configMapName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		existing.Data = configMap.Data
+		if _, err := clientset.CoreV1().ConfigMaps(cluster.Namespace).Update(existing); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pgAdminBackendModeAnnotation and its values mirror backendModeAnnotation
+// and the backendMode constants in internal/pgadmin, and tell GetPgAdminQueryRunner which
+// QueryRunner implementation to use for this Deployment
+const (
+	pgAdminBackendModeAnnotation = "pgo-pgadmin-backend"
+	pgAdminBackendModeSQLite     = "sqlite"
+	pgAdminBackendModeSQL        = "sql"
+	pgAdminBackendModeHTTP       = "http"
+)
+
+// annotatePgAdminBackendMode records how this pgAdmin's configuration
+// database should be reached: directly over SQL when it's a shared,
+// external database, through pgAdmin's own REST API when it's exposed via
+// Ingress with an admin account Secret on hand, or by exec-ing into the
+// pod's SQLite file otherwise. Only the name of the admin account Secret is
+// recorded, never its contents - annotations aren't secret, so
+// pgadmin.newHTTPQueryRunner reads the Secret itself at construction time
+func annotatePgAdminBackendMode(deployment *appsv1.Deployment, exposeSpec pgAdminExposeSpec, backendSpec pgAdminBackendSpec) {
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+
+	mode := pgAdminBackendModeSQLite
+	switch {
+	case backendSpec.Shared():
+		mode = pgAdminBackendModeSQL
+		deployment.Annotations["pgo-pgadmin-configdb-host"] = backendSpec.ConfigDBHost
+		deployment.Annotations["pgo-pgadmin-configdb-port"] = backendSpec.ConfigDBPort
+		deployment.Annotations["pgo-pgadmin-configdb-user"] = backendSpec.ConfigDBUser
+		deployment.Annotations["pgo-pgadmin-configdb-password-secret"] = backendSpec.ConfigDBPasswordSecret
+	case exposeSpec.Type == pgAdminExposeIngress && exposeSpec.HTTPAdminSecret != "":
+		mode = pgAdminBackendModeHTTP
+
+		scheme := "http"
+		if exposeSpec.IssuerName != "" {
+			scheme = "https"
+		}
+
+		deployment.Annotations["pgo-pgadmin-http-url"] = fmt.Sprintf("%s://%s%s", scheme, exposeSpec.IngressHost, exposeSpec.IngressPath)
+		deployment.Annotations["pgo-pgadmin-http-admin-secret"] = exposeSpec.HTTPAdminSecret
+	}
+
+	deployment.Annotations[pgAdminBackendModeAnnotation] = mode
+}
+
+// mountPgAdminOAuthSecret projects the user-referenced OAuth2 client
+// credentials Secret into the pgAdmin container as environment variables
+// (e.g. GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET), which oauthConfigHelperPy
+// reads at startup
+func mountPgAdminOAuthSecret(deployment *appsv1.Deployment, oauthSpec pgAdminOAuthSpec) {
+	container := &deployment.Spec.Template.Spec.Containers[0]
+
+	container.EnvFrom = append(container.EnvFrom, v1.EnvFromSource{
+		SecretRef: &v1.SecretEnvSource{
+			LocalObjectReference: v1.LocalObjectReference{Name: oauthSpec.SecretName},
+		},
+	})
+}
+
+// mountPgAdminConfigDBPassword injects the shared configuration database's
+// password into the pgAdmin container as PGADMIN_CONFIG_DB_PASSWORD, which
+// configLocalDBFormat reads at startup to build the SQLAlchemy URI
+func mountPgAdminConfigDBPassword(deployment *appsv1.Deployment, backendSpec pgAdminBackendSpec) {
+	container := &deployment.Spec.Template.Spec.Containers[0]
+
+	container.Env = append(container.Env, v1.EnvVar{
+		Name: "PGADMIN_CONFIG_DB_PASSWORD",
+		ValueFrom: &v1.EnvVarSource{
+			SecretKeyRef: &v1.SecretKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: backendSpec.ConfigDBPasswordSecret},
+				Key:                  "password",
+			},
+		},
+	})
+}
+
+// mountPgAdminConfigLocal wires the rendered config_local.py ConfigMap into
+// the pgAdmin container of an already-unmarshalled Deployment
+func mountPgAdminConfigLocal(deployment *appsv1.Deployment, clusterName string) {
+	const volumeName = "pgadmin-config-local"
+
+	configMapName := fmt.Sprintf(pgAdminConfigLocalConfigMapFormat, clusterName)
+
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+		Name: volumeName,
+		VolumeSource: v1.VolumeSource{
+			ConfigMap: &v1.ConfigMapVolumeSource{
+				LocalObjectReference: v1.LocalObjectReference{Name: configMapName},
+			},
+		},
+	})
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+		Name:      volumeName,
+		MountPath: "/etc/pgadmin/config_local.py",
+		SubPath:   "config_local.py",
+	})
+}
+
+// createPgAdminService creates the Kubernetes Service for pgAdmin. When
+// exposeSpec selects Ingress, the Service stays a plain in-cluster
+// ClusterIP and the Ingress is what actually exposes pgAdmin; otherwise the
+// Service type is set directly from exposeSpec
+func createPgAdminService(clientset kubernetes.Interface, cluster *crv1.Pgcluster, exposeSpec pgAdminExposeSpec) error {
 	// pgAdminServiceName is the name of the Service of the pgAdmin, which
 	// matches that for the Deploymnt
 	pgAdminSvcName := fmt.Sprintf(pgAdminDeploymentFormat, cluster.Name)
@@ -417,6 +1052,16 @@ func createPgAdminService(clientset kubernetes.Interface, cluster *crv1.Pgcluste
 		return err
 	}
 
+	switch exposeSpec.Type {
+	case pgAdminExposeNodePort:
+		service.Spec.Type = v1.ServiceTypeNodePort
+	case pgAdminExposeLoadBalancer:
+		service.Spec.Type = v1.ServiceTypeLoadBalancer
+	default:
+		// both ClusterIP and Ingress are backed by a ClusterIP Service
+		service.Spec.Type = v1.ServiceTypeClusterIP
+	}
+
 	if _, err := clientset.CoreV1().Services(cluster.Namespace).Create(&service); err != nil {
 		return err
 	}
@@ -424,6 +1069,97 @@ func createPgAdminService(clientset kubernetes.Interface, cluster *crv1.Pgcluste
 	return nil
 }
 
+// createPgAdminIngress creates the Kubernetes Ingress that fronts the
+// pgAdmin Service when the user has selected the Ingress expose type. When
+// exposeSpec.IssuerName is set, the Ingress is annotated so that
+// cert-manager provisions a TLS certificate for it
+func createPgAdminIngress(clientset kubernetes.Interface, cluster *crv1.Pgcluster, exposeSpec pgAdminExposeSpec) error {
+	pgAdminSvcName := fmt.Sprintf(pgAdminDeploymentFormat, cluster.Name)
+	pgAdminIngressName := fmt.Sprintf(pgAdminIngressFormat, cluster.Name)
+
+	pathType := networkingv1.PathTypePrefix
+	port, err := intstrFromPgAdminPort()
+	if err != nil {
+		return err
+	}
+
+	ingress := networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pgAdminIngressName,
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				config.LABEL_PG_CLUSTER: cluster.Name,
+				config.LABEL_PGADMIN:    "true",
+			},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: exposeSpec.IngressHost,
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{
+									Path:     exposeSpec.IngressPath,
+									PathType: &pathType,
+									Backend: networkingv1.IngressBackend{
+										Service: &networkingv1.IngressServiceBackend{
+											Name: pgAdminSvcName,
+											Port: port,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if exposeSpec.IngressClass != "" {
+		ingress.Spec.IngressClassName = &exposeSpec.IngressClass
+	}
+
+	if exposeSpec.IssuerName != "" {
+		annotationKey := certManagerClusterIssuerAnnotation
+		if exposeSpec.IssuerKind == "Issuer" {
+			annotationKey = certManagerIssuerAnnotation
+		}
+
+		ingress.ObjectMeta.Annotations = map[string]string{
+			annotationKey: exposeSpec.IssuerName,
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{
+			{
+				Hosts:      []string{exposeSpec.IngressHost},
+				SecretName: pgAdminIngressName + "-tls",
+			},
+		}
+	}
+
+	if _, err := clientset.NetworkingV1().Ingresses(cluster.Namespace).Create(&ingress); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// intstrFromPgAdminPort builds the IngressServiceBackendPort that points at
+// the pgAdmin Service's port. This targets the port by number rather than by
+// name, since nothing guarantees the port PgAdminServiceTemplate renders is
+// named "http"
+func intstrFromPgAdminPort() (networkingv1.ServiceBackendPort, error) {
+	port, err := strconv.Atoi(defPgAdminPort)
+	if err != nil {
+		return networkingv1.ServiceBackendPort{}, fmt.Errorf("invalid pgAdmin port [%s]: %w", defPgAdminPort, err)
+	}
+
+	return networkingv1.ServiceBackendPort{
+		Number: int32(port),
+	}, nil
+}
+
 // publishPgAdminEvent publishes one of the events on the event stream
 func publishPgAdminEvent(eventType string, task *crv1.Pgtask) {
 	var event events.EventInterface
@@ -460,28 +1196,54 @@ func publishPgAdminEvent(eventType string, task *crv1.Pgtask) {
 	}
 }
 
-// waitFotDeploymentReady waits for a deployment to be ready, or times out
-func waitForDeploymentReady(clientset kubernetes.Interface, namespace, deploymentName string, timeoutSecs, periodSecs time.Duration) error {
-	timeout := time.After(timeoutSecs * time.Second)
-	tick := time.NewTicker(periodSecs * time.Second)
-	defer tick.Stop()
-
-	// loop until the timeout is met, or that all the replicas are ready
-	for {
-		select {
-		case <-timeout:
-			return errors.New(fmt.Sprintf("Timed out waiting for deployment to become ready: [%s]", deploymentName))
-		case <-tick.C:
-			if deployment, err := clientset.AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{}); err != nil {
-				// if there is an error, log it but continue through the loop
-				log.Error(err)
-			} else {
-				// check to see if the deployment status has succeed...if so, break out
-				// of the loop
-				if deployment.Status.ReadyReplicas == *deployment.Spec.Replicas {
-					return nil
-				}
+// waitForDeploymentReady waits for a deployment to be ready, or times out.
+// Rather than polling on a fixed interval, it watches the Deployment
+// starting from the ResourceVersion observed by the initial Get, so it
+// notices readiness as soon as the status is updated instead of up to a
+// polling interval later
+func waitForDeploymentReady(clientset kubernetes.Interface, namespace, deploymentName string, timeoutSecs time.Duration) error {
+	current, err := clientset.AppsV1().Deployments(namespace).Get(deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if current.Status.ReadyReplicas == *current.Spec.Replicas {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeoutSecs*time.Second)
+	defer cancel()
+
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", deploymentName).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return clientset.AppsV1().Deployments(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			options.ResourceVersion = current.ResourceVersion
+			return clientset.AppsV1().Deployments(namespace).Watch(options)
+		},
+	}
+
+	_, err = watchtools.UntilWithSync(ctx, lw, &appsv1.Deployment{}, nil,
+		func(event watch.Event) (bool, error) {
+			if event.Type == watch.Deleted {
+				return false, fmt.Errorf("deployment [%s] was deleted while waiting for it to become ready", deploymentName)
 			}
-		}
+
+			deployment, ok := event.Object.(*appsv1.Deployment)
+			if !ok {
+				return false, nil
+			}
+
+			return deployment.Status.ReadyReplicas == *deployment.Spec.Replicas, nil
+		})
+
+	if err != nil {
+		return fmt.Errorf("timed out waiting for deployment to become ready: [%s]: %w", deploymentName, err)
 	}
+
+	return nil
 }
@@ -0,0 +1,251 @@
+package cluster
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/crunchydata/postgres-operator/internal/config"
+	"github.com/crunchydata/postgres-operator/internal/kubeapi"
+	crv1 "github.com/crunchydata/postgres-operator/pkg/apis/crunchydata.com/v1"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// bootstrapBackoff bounds how long ReconcilePgcluster and OnUserSecretChange
+// will keep retrying BootstrapPgAdminUsers while the pgAdmin pod isn't
+// ready yet, rather than giving up after a single attempt
+var bootstrapBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2.0,
+	Steps:    5,
+}
+
+// pgAdminController reconciles the pgAdmin lifecycle (AddPgAdmin,
+// DeletePgAdmin and the user bootstrap) for Pgcluster resources. Every
+// method here is safe to call repeatedly - on operator restart, on any
+// Pgcluster update, or whenever a Postgres user Secret is added or its
+// password rotates - since it always compares against the Deployment's
+// current state before acting.
+//
+// Start wires ReconcilePgcluster and OnUserSecretChange to Pgcluster and
+// Secret informers of its own, so this type is both the reconcile logic
+// and the thing that drives it. Nothing in the operator calls Start, though:
+// AddPgAdminFromPgTask and DeletePgAdminFromPgTask still call AddPgAdmin and
+// DeletePgAdmin directly, and only bootstrapWithBackoff is used from here.
+type pgAdminController struct {
+	clientset  kubeapi.Interface
+	restconfig *rest.Config
+}
+
+// newPgAdminController returns a pgAdminController for the given clientset
+func newPgAdminController(clientset kubeapi.Interface, restconfig *rest.Config) *pgAdminController {
+	return &pgAdminController{clientset: clientset, restconfig: restconfig}
+}
+
+// Start begins watching Pgcluster and Secret events in namespace, driving
+// ReconcilePgcluster and OnUserSecretChange from them until stopCh is
+// closed. It returns once both informer caches have synced
+func (c *pgAdminController) Start(namespace string, stopCh <-chan struct{}) {
+	clusterInformer := cache.NewSharedInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return c.clientset.CrunchydataV1().Pgclusters(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return c.clientset.CrunchydataV1().Pgclusters(namespace).Watch(options)
+			},
+		},
+		&crv1.Pgcluster{}, 0)
+
+	clusterInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onPgclusterEvent,
+		UpdateFunc: func(_, newObj interface{}) { c.onPgclusterEvent(newObj) },
+	})
+
+	secretInformer := cache.NewSharedInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.LabelSelector = config.LABEL_PG_CLUSTER
+				return c.clientset.CoreV1().Secrets(namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.LabelSelector = config.LABEL_PG_CLUSTER
+				return c.clientset.CoreV1().Secrets(namespace).Watch(options)
+			},
+		},
+		&v1.Secret{}, 0)
+
+	secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onSecretEvent,
+		UpdateFunc: func(_, newObj interface{}) { c.onSecretEvent(newObj) },
+	})
+
+	go clusterInformer.Run(stopCh)
+	go secretInformer.Run(stopCh)
+
+	cache.WaitForCacheSync(stopCh, clusterInformer.HasSynced, secretInformer.HasSynced)
+}
+
+// onPgclusterEvent resyncs the pgAdmin Deployment for a Pgcluster that was
+// added or updated. Creation parameters (storage class, expose/backend/OAuth
+// mode) only matter the first time pgAdmin is added for a cluster, or if its
+// Deployment needs to be recreated after going missing unexpectedly - in
+// both cases they're read back from the pgAdminParamsAnnotation AddPgAdmin
+// persisted on cluster. Most of the time this handler's job is the
+// idempotent resync - noticing pgAdmin already exists (or shouldn't
+// anymore) and keeping its users bootstrapped - where those parameters go
+// unused
+func (c *pgAdminController) onPgclusterEvent(obj interface{}) {
+	cluster, ok := obj.(*crv1.Pgcluster)
+	if !ok {
+		return
+	}
+
+	var storageClass *crv1.PgStorageSpec
+	exposeSpec, backendSpec, oauthSpec := pgAdminExposeSpec{}, pgAdminBackendSpec{}, pgAdminOAuthSpec{}
+
+	if params, ok := pgAdminCreationParamsFromCluster(cluster); ok {
+		storageClass = &params.Storage
+		exposeSpec, backendSpec, oauthSpec = params.Expose, params.Backend, params.OAuth
+	}
+
+	if err := c.ReconcilePgcluster(cluster, storageClass, exposeSpec, backendSpec, oauthSpec); err != nil {
+		log.Errorf("pgAdmin reconcile failed for cluster [%s]: %v", cluster.Name, err)
+	}
+}
+
+// onSecretEvent re-bootstraps the owning cluster's pgAdmin users whenever
+// one of its Postgres user Secrets is added or updated, which is what
+// catches a rotated password before it silently breaks that user's pgAdmin
+// login
+func (c *pgAdminController) onSecretEvent(obj interface{}) {
+	secret, ok := obj.(*v1.Secret)
+	if !ok {
+		return
+	}
+
+	clusterName := secret.Labels[config.LABEL_PG_CLUSTER]
+	if clusterName == "" {
+		return
+	}
+
+	cluster, err := c.clientset.CrunchydataV1().Pgclusters(secret.Namespace).Get(clusterName, metav1.GetOptions{})
+	if err != nil {
+		if !kerrors.IsNotFound(err) {
+			log.Errorf("pgAdmin secret resync could not load cluster [%s]: %v", clusterName, err)
+		}
+		return
+	}
+
+	if err := c.OnUserSecretChange(cluster); err != nil {
+		log.Errorf("pgAdmin user resync failed for cluster [%s]: %v", cluster.Name, err)
+	}
+}
+
+// ReconcilePgcluster brings the pgAdmin Deployment for cluster in line with
+// its desired state, then (re-)bootstraps its users. Unlike calling
+// AddPgAdmin/DeletePgAdmin directly from a pgtask, it is idempotent.
+//
+// storageClass may be nil when the caller has no creation parameters on
+// hand (e.g. they weren't persisted on cluster yet): that's fine as long as
+// the Deployment already exists or isn't wanted, but ReconcilePgcluster
+// refuses to create it from nil parameters rather than guessing its mode
+func (c *pgAdminController) ReconcilePgcluster(
+	cluster *crv1.Pgcluster,
+	storageClass *crv1.PgStorageSpec,
+	exposeSpec pgAdminExposeSpec,
+	backendSpec pgAdminBackendSpec,
+	oauthSpec pgAdminOAuthSpec) error {
+
+	wantsPgAdmin := cluster.Labels[config.LABEL_PGADMIN] == "true"
+
+	deployName := fmt.Sprintf(pgAdminDeploymentFormat, cluster.Name)
+	_, err := c.clientset.AppsV1().Deployments(cluster.Namespace).Get(deployName, metav1.GetOptions{})
+	switch {
+	case err == nil && !wantsPgAdmin:
+		return DeletePgAdmin(c.clientset, c.restconfig, cluster)
+	case err == nil:
+		// already exists and still wanted - nothing to create, just make
+		// sure its users are bootstrapped below
+	case kerrors.IsNotFound(err) && wantsPgAdmin:
+		if storageClass == nil {
+			log.Warnf("pgAdmin is wanted for cluster [%s] but its Deployment is missing and no creation parameters are available; refusing to recreate it rather than guessing its mode", cluster.Name)
+			return nil
+		}
+		if err := AddPgAdmin(c.clientset, c.restconfig, cluster, storageClass, exposeSpec, backendSpec, oauthSpec); err != nil {
+			return err
+		}
+	case kerrors.IsNotFound(err):
+		// not wanted and doesn't exist - nothing to do
+		return nil
+	default:
+		return err
+	}
+
+	if !wantsPgAdmin {
+		return nil
+	}
+
+	deployName = fmt.Sprintf(pgAdminDeploymentFormat, cluster.Name)
+	if err := waitForDeploymentReady(c.clientset, cluster.Namespace, deployName, deployTimeout); err != nil {
+		return err
+	}
+
+	return c.bootstrapWithBackoff(cluster, oauthSpec)
+}
+
+// OnUserSecretChange re-syncs the pgAdmin user table for cluster whenever a
+// Postgres user Secret is added or its password is rotated. Without this,
+// a rotated Postgres password silently breaks that user's pgAdmin login
+// until someone happens to re-trigger a full reconcile
+func (c *pgAdminController) OnUserSecretChange(cluster *crv1.Pgcluster) error {
+	if cluster.Labels[config.LABEL_PGADMIN] != "true" {
+		return nil
+	}
+
+	params, _ := pgAdminCreationParamsFromCluster(cluster)
+	return c.bootstrapWithBackoff(cluster, params.OAuth)
+}
+
+// bootstrapWithBackoff retries BootstrapPgAdminUsers, and BootstrapPgAdminOAuthUsers
+// when SSO is enabled, with exponential backoff, since the pgAdmin pod may
+// briefly not be ready (e.g. right after AddPgAdmin, or right after a
+// rolling update)
+func (c *pgAdminController) bootstrapWithBackoff(cluster *crv1.Pgcluster, oauthSpec pgAdminOAuthSpec) error {
+	return retry.OnError(bootstrapBackoff, func(err error) bool { return err != nil }, func() error {
+		if err := BootstrapPgAdminUsers(c.clientset, c.restconfig, cluster); err != nil {
+			log.Debugf("pgAdmin bootstrap not ready yet for cluster [%s]: %v", cluster.Name, err)
+			return err
+		}
+
+		if oauthSpec.Enabled() {
+			return BootstrapPgAdminOAuthUsers(c.clientset, c.restconfig, cluster)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,282 @@
+package cluster
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPgAdminExposeSpecFromParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   pgAdminExposeSpec
+	}{
+		{
+			name:       "defaults to ClusterIP",
+			parameters: map[string]string{},
+			expected: pgAdminExposeSpec{
+				Type:        pgAdminExposeClusterIP,
+				IngressPath: "/",
+				IssuerKind:  "ClusterIssuer",
+			},
+		},
+		{
+			name: "NodePort passes through unchanged",
+			parameters: map[string]string{
+				paramPgAdminExposeType: string(pgAdminExposeNodePort),
+			},
+			expected: pgAdminExposeSpec{
+				Type:        pgAdminExposeNodePort,
+				IngressPath: "/",
+				IssuerKind:  "ClusterIssuer",
+			},
+		},
+		{
+			name: "Ingress defaults IngressPath and IssuerKind",
+			parameters: map[string]string{
+				paramPgAdminExposeType:  string(pgAdminExposeIngress),
+				paramPgAdminIngressHost: "pgadmin.example.com",
+			},
+			expected: pgAdminExposeSpec{
+				Type:        pgAdminExposeIngress,
+				IngressHost: "pgadmin.example.com",
+				IngressPath: "/",
+				IssuerKind:  "ClusterIssuer",
+			},
+		},
+		{
+			name: "Ingress with an explicit path, class and cert-manager Issuer",
+			parameters: map[string]string{
+				paramPgAdminExposeType:      string(pgAdminExposeIngress),
+				paramPgAdminIngressHost:     "pgadmin.example.com",
+				paramPgAdminIngressPath:     "/pgadmin",
+				paramPgAdminIngressClass:    "nginx",
+				paramPgAdminIssuerName:      "my-issuer",
+				paramPgAdminIssuerKind:      "Issuer",
+				paramPgAdminHTTPAdminSecret: "pgadmin-admin-creds",
+			},
+			expected: pgAdminExposeSpec{
+				Type:            pgAdminExposeIngress,
+				IngressHost:     "pgadmin.example.com",
+				IngressPath:     "/pgadmin",
+				IngressClass:    "nginx",
+				IssuerName:      "my-issuer",
+				IssuerKind:      "Issuer",
+				HTTPAdminSecret: "pgadmin-admin-creds",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := pgAdminExposeSpecFromParameters(test.parameters)
+			if actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPgAdminBackendSpecFromParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   pgAdminBackendSpec
+	}{
+		{
+			name:       "defaults to a single replica with no shared backend",
+			parameters: map[string]string{},
+			expected: pgAdminBackendSpec{
+				Replicas:     1,
+				ConfigDBPort: defConfigDBPort,
+			},
+		},
+		{
+			name: "shared backend fills in the default configdb port",
+			parameters: map[string]string{
+				paramPgAdminConfigDBHost:           "configdb.example.com",
+				paramPgAdminConfigDBUser:           "pgadmin",
+				paramPgAdminConfigDBPasswordSecret: "pgadmin-configdb-secret",
+			},
+			expected: pgAdminBackendSpec{
+				Replicas:               1,
+				ConfigDBHost:           "configdb.example.com",
+				ConfigDBPort:           defConfigDBPort,
+				ConfigDBUser:           "pgadmin",
+				ConfigDBPasswordSecret: "pgadmin-configdb-secret",
+			},
+		},
+		{
+			name: "explicit replicas and configdb port pass through unchanged",
+			parameters: map[string]string{
+				paramPgAdminReplicas:               "3",
+				paramPgAdminConfigDBHost:           "configdb.example.com",
+				paramPgAdminConfigDBPort:           "6432",
+				paramPgAdminConfigDBUser:           "pgadmin",
+				paramPgAdminConfigDBPasswordSecret: "pgadmin-configdb-secret",
+			},
+			expected: pgAdminBackendSpec{
+				Replicas:               3,
+				ConfigDBHost:           "configdb.example.com",
+				ConfigDBPort:           "6432",
+				ConfigDBUser:           "pgadmin",
+				ConfigDBPasswordSecret: "pgadmin-configdb-secret",
+			},
+		},
+		{
+			name: "non-positive replicas fall back to 1",
+			parameters: map[string]string{
+				paramPgAdminReplicas: "0",
+			},
+			expected: pgAdminBackendSpec{
+				Replicas:     1,
+				ConfigDBPort: defConfigDBPort,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := pgAdminBackendSpecFromParameters(test.parameters)
+			if actual != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPgAdminOAuthSpecFromParameters(t *testing.T) {
+	tests := []struct {
+		name       string
+		parameters map[string]string
+		expected   pgAdminOAuthSpec
+	}{
+		{
+			name:       "disabled when no providers are given",
+			parameters: map[string]string{},
+			expected:   pgAdminOAuthSpec{},
+		},
+		{
+			name: "a single recognized provider",
+			parameters: map[string]string{
+				paramPgAdminOAuthProviders: "google",
+				paramPgAdminOAuthSecret:    "pgadmin-oauth-secret",
+			},
+			expected: pgAdminOAuthSpec{
+				Providers:  []string{"google"},
+				SecretName: "pgadmin-oauth-secret",
+			},
+		},
+		{
+			name: "multiple providers with surrounding whitespace",
+			parameters: map[string]string{
+				paramPgAdminOAuthProviders: "google, github , oidc",
+				paramPgAdminOAuthSecret:    "pgadmin-oauth-secret",
+			},
+			expected: pgAdminOAuthSpec{
+				Providers:  []string{"google", "github", "oidc"},
+				SecretName: "pgadmin-oauth-secret",
+			},
+		},
+		{
+			name: "unrecognized providers are dropped",
+			parameters: map[string]string{
+				paramPgAdminOAuthProviders: "google,bogus,github",
+				paramPgAdminOAuthSecret:    "pgadmin-oauth-secret",
+			},
+			expected: pgAdminOAuthSpec{
+				Providers:  []string{"google", "github"},
+				SecretName: "pgadmin-oauth-secret",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := pgAdminOAuthSpecFromParameters(test.parameters)
+
+			if actual.SecretName != test.expected.SecretName || len(actual.Providers) != len(test.expected.Providers) {
+				t.Fatalf("expected %+v, got %+v", test.expected, actual)
+			}
+			for i := range actual.Providers {
+				if actual.Providers[i] != test.expected.Providers[i] {
+					t.Fatalf("expected %+v, got %+v", test.expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderConfigLocalPy(t *testing.T) {
+	tests := []struct {
+		name              string
+		backendSpec       pgAdminBackendSpec
+		oauthSpec         pgAdminOAuthSpec
+		expectContains    []string
+		expectNotContains []string
+	}{
+		{
+			name:              "neither shared backend nor OAuth produces only the import stub",
+			expectContains:    []string{"import os\n"},
+			expectNotContains: []string{"SQLALCHEMY_DATABASE_URI", "AUTHENTICATION_SOURCES"},
+		},
+		{
+			name: "shared backend renders the SQLAlchemy URI without a password",
+			backendSpec: pgAdminBackendSpec{
+				ConfigDBHost: "configdb.example.com",
+				ConfigDBPort: "5432",
+				ConfigDBUser: "pgadmin",
+			},
+			expectContains: []string{
+				`SQLALCHEMY_DATABASE_URI`,
+				`"postgresql://pgadmin:"`,
+				`@configdb.example.com:5432/pgadmin`,
+			},
+			expectNotContains: []string{"AUTHENTICATION_SOURCES"},
+		},
+		{
+			name: "OAuth renders AUTHENTICATION_SOURCES with source identifiers, not provider names",
+			oauthSpec: pgAdminOAuthSpec{
+				Providers: []string{"google", "github"},
+			},
+			expectContains: []string{
+				`AUTHENTICATION_SOURCES = ["internal", "oauth2"]`,
+				`"google"`,
+				`"github"`,
+			},
+			expectNotContains: []string{"SQLALCHEMY_DATABASE_URI"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := renderConfigLocalPy(test.backendSpec, test.oauthSpec)
+
+			for _, s := range test.expectContains {
+				if !strings.Contains(actual, s) {
+					t.Errorf("expected rendered config_local.py to contain %q, got:\n%s", s, actual)
+				}
+			}
+			for _, s := range test.expectNotContains {
+				if strings.Contains(actual, s) {
+					t.Errorf("expected rendered config_local.py not to contain %q, got:\n%s", s, actual)
+				}
+			}
+		})
+	}
+}
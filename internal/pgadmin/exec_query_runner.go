@@ -0,0 +1,121 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"fmt"
+
+	"github.com/crunchydata/postgres-operator/internal/config"
+	"github.com/crunchydata/postgres-operator/internal/kubeapi"
+	crv1 "github.com/crunchydata/postgres-operator/pkg/apis/crunchydata.com/v1"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// execQueryRunner is the default QueryRunner: it execs into the pgAdmin pod
+// and drives its per-pod SQLite configuration database with the sqlite3 CLI
+type execQueryRunner struct {
+	clientset  kubernetes.Interface
+	restconfig *rest.Config
+	namespace  string
+	podName    string
+}
+
+// newPodExecQueryRunner finds a running pod for the pgAdmin Deployment and
+// returns an execQueryRunner that talks to it
+func newPodExecQueryRunner(clientset kubernetes.Interface, restconfig *rest.Config, cluster *crv1.Pgcluster, deploymentName string) (QueryRunner, error) {
+	selector := fmt.Sprintf("%s=%s,%s=true", config.LABEL_PG_CLUSTER, cluster.Name, config.LABEL_PGADMIN)
+	pods, err := clientset.CoreV1().Pods(cluster.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != v1.PodRunning {
+			continue
+		}
+
+		return &execQueryRunner{
+			clientset:  clientset,
+			restconfig: restconfig,
+			namespace:  cluster.Namespace,
+			podName:    pod.Name,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("no running pgAdmin pods found for deployment [%s]", deploymentName)
+}
+
+// exec runs query against the pod's SQLite configuration database
+func (r *execQueryRunner) exec(query string) error {
+	cmd := []string{"sqlite3", "/var/lib/pgadmin/pgadmin4.db", query}
+
+	stdout, stderr, err := kubeapi.ExecToPodThroughAPI(r.restconfig, r.clientset,
+		cmd, "pgadmin", r.podName, r.namespace, nil)
+	if err != nil {
+		log.Errorf("could not execute pgAdmin query pod=[%s] stdout=[%s] stderr=[%s]",
+			r.podName, stdout, stderr)
+		return err
+	}
+
+	return nil
+}
+
+func (r *execQueryRunner) LockSetupUser() error {
+	return r.exec("UPDATE user SET active = 0, password = substr(password,1,50) WHERE id=1;")
+}
+
+func (r *execQueryRunner) SetUserPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(
+		`INSERT OR REPLACE INTO user (email, password, active, auth_source) VALUES ('%s', '%s', 1, 'internal');`,
+		sqlEscape(username), sqlEscape(string(hash)))
+
+	return r.exec(query)
+}
+
+func (r *execQueryRunner) SetOAuthUser(username string) error {
+	query := fmt.Sprintf(
+		`INSERT OR REPLACE INTO user (email, password, active, auth_source) VALUES ('%s', '', 1, 'oauth2');`,
+		sqlEscape(username))
+
+	return r.exec(query)
+}
+
+func (r *execQueryRunner) SetServerConnection(username string, server ServerEntry) error {
+	query := fmt.Sprintf(
+		`INSERT OR REPLACE INTO server (user_id, name, host, port, username, sslmode)
+		 SELECT id, '%s', '%s', %d, '%s', '%s' FROM user WHERE email = '%s';`,
+		sqlEscape(server.Name), sqlEscape(server.Host), server.Port,
+		sqlEscape(username), sqlEscape(server.SSLMode), sqlEscape(username))
+
+	return r.exec(query)
+}
+
+// Close is a no-op: execQueryRunner holds no resources beyond the
+// clientset/restconfig it was given, which it doesn't own
+func (r *execQueryRunner) Close() error {
+	return nil
+}
@@ -0,0 +1,161 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/postgres-operator/pkg/apis/crunchydata.com/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// httpTimeout bounds a single call to the pgAdmin REST API
+const httpTimeout = 10 * time.Second
+
+// httpQueryRunner manages pgAdmin users and server connections through
+// pgAdmin's own REST API (/api/login, /api/user, /api/server) instead of
+// touching its configuration database directly. This is what lets the
+// operator manage a pgAdmin that it doesn't have exec or direct DB access
+// to, e.g. one fronted only by an Ingress
+type httpQueryRunner struct {
+	client    *http.Client
+	baseURL   string
+	adminUser string
+	adminPass string
+}
+
+// newHTTPQueryRunner builds an httpQueryRunner for the pgAdmin Deployment,
+// reading its base URL from an annotation set by internal/operator/cluster
+// when the Deployment was created, and its admin credentials from the
+// Secret that annotation names. The credentials are read live from the
+// Secret rather than from the Deployment itself, since annotations aren't
+// secret
+func newHTTPQueryRunner(clientset kubernetes.Interface, cluster *crv1.Pgcluster, deployment *appsv1.Deployment) (QueryRunner, error) {
+	baseURL := deployment.Annotations["pgo-pgadmin-http-url"]
+	if baseURL == "" {
+		return nil, fmt.Errorf("deployment [%s] is missing the pgo-pgadmin-http-url annotation required for the http QueryRunner", deployment.Name)
+	}
+
+	secretName := deployment.Annotations["pgo-pgadmin-http-admin-secret"]
+	if secretName == "" {
+		return nil, fmt.Errorf("deployment [%s] is missing the pgo-pgadmin-http-admin-secret annotation required for the http QueryRunner", deployment.Name)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(deployment.Namespace).Get(secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpQueryRunner{
+		client:    &http.Client{Jar: jar, Timeout: httpTimeout},
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		adminUser: string(secret.Data["username"]),
+		adminPass: string(secret.Data["password"]),
+	}, nil
+}
+
+// login authenticates against /api/login and stashes the resulting session
+// cookie in r.client's cookie jar for subsequent calls
+func (r *httpQueryRunner) login() error {
+	form := url.Values{"email": {r.adminUser}, "password": {r.adminPass}}
+
+	resp, err := r.client.PostForm(r.baseURL+"/api/login", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pgAdmin login failed: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// post logs in and POSTs the JSON-encoded body to path
+func (r *httpQueryRunner) post(path string, body map[string]interface{}) error {
+	if err := r.login(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Post(r.baseURL+path, "application/json", strings.NewReader(string(payload)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("pgAdmin API call to %s failed: %s", path, resp.Status)
+	}
+
+	return nil
+}
+
+func (r *httpQueryRunner) LockSetupUser() error {
+	return r.post("/api/user/1", map[string]interface{}{"active": false})
+}
+
+func (r *httpQueryRunner) SetUserPassword(username, password string) error {
+	return r.post("/api/user", map[string]interface{}{
+		"email":       username,
+		"password":    password,
+		"auth_source": "internal",
+		"active":      true,
+	})
+}
+
+func (r *httpQueryRunner) SetOAuthUser(username string) error {
+	return r.post("/api/user", map[string]interface{}{
+		"email":       username,
+		"auth_source": "oauth2",
+		"active":      true,
+	})
+}
+
+func (r *httpQueryRunner) SetServerConnection(username string, server ServerEntry) error {
+	return r.post("/api/server", map[string]interface{}{
+		"owner":   username,
+		"name":    server.Name,
+		"host":    server.Host,
+		"port":    server.Port,
+		"sslmode": server.SSLMode,
+	})
+}
+
+// Close is a no-op: httpQueryRunner holds no resources beyond its
+// *http.Client, which doesn't need to be closed
+func (r *httpQueryRunner) Close() error {
+	return nil
+}
@@ -0,0 +1,27 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// SetOAuthUser creates or updates a pgAdmin user that authenticates via an
+// OAuth2/OIDC identity provider instead of a pgAdmin-managed password. Like
+// SetLoginPassword, it only sets up the user; their server connection is
+// registered separately with SetClusterConnection. Since the identity
+// provider owns the credential,
+// there's no password to store - pgAdmin resolves the login at OAuth
+// callback time by matching on email
+func SetOAuthUser(qr QueryRunner, username string) error {
+	return qr.SetOAuthUser(username)
+}
@@ -0,0 +1,53 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// ServerEntry holds the connection details for a PostgreSQL cluster that get
+// registered in pgAdmin's "server" table so a logged-in user sees it in
+// their connection tree without having to add it by hand
+type ServerEntry struct {
+	Name     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	SSLMode  string
+}
+
+// ServerEntryFromPgService builds a ServerEntry from the Kubernetes Service
+// that fronts a PostgreSQL cluster
+func ServerEntryFromPgService(service *v1.Service, clusterName string) ServerEntry {
+	entry := ServerEntry{
+		Name:    clusterName,
+		Host:    service.Name,
+		SSLMode: "prefer",
+	}
+
+	for _, port := range service.Spec.Ports {
+		entry.Port = int(port.Port)
+		break
+	}
+
+	if entry.Port == 0 {
+		entry.Port = 5432
+	}
+
+	return entry
+}
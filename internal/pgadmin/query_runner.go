@@ -0,0 +1,110 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/crunchydata/postgres-operator/internal/config"
+	crv1 "github.com/crunchydata/postgres-operator/pkg/apis/crunchydata.com/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// backendModeAnnotation is set on the pgAdmin Deployment (by
+// internal/operator/cluster) to record which QueryRunner implementation
+// GetPgAdminQueryRunner should use to talk to it
+const backendModeAnnotation = "pgo-pgadmin-backend"
+
+// backendMode names the supported QueryRunner implementations
+type backendMode string
+
+const (
+	// backendModeSQLite execs into the pgAdmin pod and runs sqlite3 against
+	// its per-pod configuration database. This is the default when the
+	// Deployment carries no backendModeAnnotation
+	backendModeSQLite backendMode = "sqlite"
+	// backendModeHTTP drives pgAdmin's own REST API instead of touching its
+	// configuration database directly
+	backendModeHTTP backendMode = "http"
+	// backendModeSQL connects directly to the shared Postgres configuration
+	// database used when pgAdmin is deployed with replicas > 1
+	backendModeSQL backendMode = "sql"
+)
+
+// QueryRunner is how this package manages pgAdmin users and their server
+// connections, independent of whether pgAdmin's configuration database is
+// the default per-pod SQLite file, a shared Postgres backend, or reached
+// only through pgAdmin's own REST API
+type QueryRunner interface {
+	// LockSetupUser disables the one-time setup user created when pgAdmin
+	// first starts, so its throwaway credentials can no longer be used to
+	// log in
+	LockSetupUser() error
+
+	// SetUserPassword creates or updates a pgAdmin user that logs in with a
+	// username/password
+	SetUserPassword(username, password string) error
+
+	// SetOAuthUser creates or updates a pgAdmin user that logs in through an
+	// OAuth2/OIDC identity provider
+	SetOAuthUser(username string) error
+
+	// SetServerConnection registers a PostgreSQL server connection for
+	// username, so it appears in their connection tree automatically
+	SetServerConnection(username string, server ServerEntry) error
+
+	// Close releases any resources the QueryRunner is holding open, e.g. the
+	// sqlQueryRunner's database connection pool. Callers should Close every
+	// QueryRunner they get from GetPgAdminQueryRunner once they're done with it
+	Close() error
+}
+
+// GetPgAdminQueryRunner returns the QueryRunner for the pgAdmin Deployment
+// associated with cluster, or nil if the cluster doesn't have pgAdmin
+// enabled. The concrete implementation returned is chosen from the
+// backendModeAnnotation recorded on that Deployment
+func GetPgAdminQueryRunner(clientset kubernetes.Interface, restconfig *rest.Config, cluster *crv1.Pgcluster) (QueryRunner, error) {
+	if cluster.Labels[config.LABEL_PGADMIN] != "true" {
+		return nil, nil
+	}
+
+	deploymentName := fmt.Sprintf("%s-pgadmin", cluster.Name)
+
+	deployment, err := clientset.AppsV1().Deployments(cluster.Namespace).Get(deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	switch backendMode(deployment.Annotations[backendModeAnnotation]) {
+	case backendModeHTTP:
+		return newHTTPQueryRunner(clientset, cluster, deployment)
+	case backendModeSQL:
+		return newSQLQueryRunner(clientset, cluster, deployment)
+	default:
+		return newPodExecQueryRunner(clientset, restconfig, cluster, deploymentName)
+	}
+}
+
+// sqlEscape escapes single quotes for values interpolated into queries that
+// go through the sqlite exec path, since sqlite3's CLI doesn't offer bound
+// parameters over kubeapi.ExecToPodThroughAPI
+func sqlEscape(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
@@ -0,0 +1,112 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+import (
+	"database/sql"
+	"fmt"
+
+	crv1 "github.com/crunchydata/postgres-operator/pkg/apis/crunchydata.com/v1"
+
+	_ "github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// sqlQueryRunner manages pgAdmin users and server connections by connecting
+// directly to the shared, external Postgres database used when pgAdmin is
+// deployed with replicas > 1 (see internal/operator/cluster's
+// pgAdminBackendSpec), rather than exec-ing into any one pod
+type sqlQueryRunner struct {
+	db *sql.DB
+}
+
+// newSQLQueryRunner opens a connection to the shared configuration database
+// referenced by the annotations internal/operator/cluster sets on the
+// pgAdmin Deployment when it's created in shared-backend mode
+func newSQLQueryRunner(clientset kubernetes.Interface, cluster *crv1.Pgcluster, deployment *appsv1.Deployment) (QueryRunner, error) {
+	host := deployment.Annotations["pgo-pgadmin-configdb-host"]
+	port := deployment.Annotations["pgo-pgadmin-configdb-port"]
+	user := deployment.Annotations["pgo-pgadmin-configdb-user"]
+	passwordSecret := deployment.Annotations["pgo-pgadmin-configdb-password-secret"]
+
+	if host == "" {
+		return nil, fmt.Errorf("deployment [%s] is missing the pgo-pgadmin-configdb-host annotation required for the sql QueryRunner", deployment.Name)
+	}
+	if passwordSecret == "" {
+		return nil, fmt.Errorf("deployment [%s] is missing the pgo-pgadmin-configdb-password-secret annotation required for the sql QueryRunner", deployment.Name)
+	}
+
+	// read the password from the per-cluster Secret this Deployment was
+	// actually configured with, rather than a single operator-wide value,
+	// since more than one cluster can be running in shared-backend mode at
+	// once
+	secret, err := clientset.CoreV1().Secrets(deployment.Namespace).Get(passwordSecret, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	password := string(secret.Data["password"])
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=pgadmin sslmode=prefer",
+		host, port, user, password)
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sqlQueryRunner{db: db}, nil
+}
+
+func (r *sqlQueryRunner) LockSetupUser() error {
+	_, err := r.db.Exec(`UPDATE "user" SET active = false, password = substr(password, 1, 50) WHERE id = 1;`)
+	return err
+}
+
+func (r *sqlQueryRunner) SetUserPassword(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO "user" (email, password, active, auth_source) VALUES ($1, $2, true, 'internal')
+		 ON CONFLICT (email) DO UPDATE SET password = EXCLUDED.password, active = true`,
+		username, string(hash))
+	return err
+}
+
+func (r *sqlQueryRunner) SetOAuthUser(username string) error {
+	_, err := r.db.Exec(
+		`INSERT INTO "user" (email, password, active, auth_source) VALUES ($1, '', true, 'oauth2')
+		 ON CONFLICT (email) DO UPDATE SET active = true, auth_source = 'oauth2'`,
+		username)
+	return err
+}
+
+func (r *sqlQueryRunner) SetServerConnection(username string, server ServerEntry) error {
+	_, err := r.db.Exec(
+		`INSERT INTO server (user_id, name, host, port, username, sslmode)
+		 SELECT id, $2, $3, $4, $5, $6 FROM "user" WHERE email = $1`,
+		username, server.Name, server.Host, server.Port, username, server.SSLMode)
+	return err
+}
+
+func (r *sqlQueryRunner) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,30 @@
+package pgadmin
+
+/*
+ Copyright 2020 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// SetLoginPassword creates or updates a pgAdmin user with a username/password
+// login, so the user can log in through the normal login form. How the
+// password is actually stored is up to qr's backend
+func SetLoginPassword(qr QueryRunner, username, password string) error {
+	return qr.SetUserPassword(username, password)
+}
+
+// SetClusterConnection registers a PostgreSQL cluster connection for the
+// named user, so it shows up in their connection tree without them having
+// to add it by hand
+func SetClusterConnection(qr QueryRunner, username string, server ServerEntry) error {
+	return qr.SetServerConnection(username, server)
+}